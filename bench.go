@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
 	"os"
-	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,8 +28,10 @@ type Query struct {
 // Result holds benchmark results
 type Result struct {
 	AvgLatencyMs      float64 `json:"avg_latency_ms"`
+	P50LatencyMs      float64 `json:"p50_latency_ms"`
 	P95LatencyMs      float64 `json:"p95_latency_ms"`
 	P99LatencyMs      float64 `json:"p99_latency_ms"`
+	P999LatencyMs     float64 `json:"p999_latency_ms"`
 	ThroughputReqSec  float64 `json:"throughput_req_sec"`
 	SuccessCount      int64   `json:"success_count"`
 	ErrorCount        int64   `json:"error_count"`
@@ -37,6 +39,131 @@ type Result struct {
 	ElapsedSeconds    float64 `json:"elapsed_seconds"`
 	WarmupRequests    int     `json:"warmup_requests"`
 	BenchmarkRequests int     `json:"benchmark_requests"`
+
+	// LatencyHistogram is a percentile -> latency-ms dump of the benchmark
+	// phase's latency histogram, so runs can be compared later without
+	// replaying requests. Only the percentiles in latencyHistogramPercentiles
+	// are kept, not the bucket counts.
+	LatencyHistogram map[string]float64 `json:"latency_histogram_ms"`
+
+	LoadModel string  `json:"load_model"`
+	TargetRPS float64 `json:"target_rps,omitempty"`
+
+	// SchedulingDelay is a percentile -> delay-ms dump of how long a request
+	// waited between its intended (scheduled) start and when a worker
+	// actually picked it up. Populated only in open-loop mode (constant or
+	// poisson); closed-loop runs have no independent schedule to measure
+	// against.
+	SchedulingDelay map[string]float64 `json:"scheduling_delay_ms,omitempty"`
+
+	// RetryCount is the total number of retry attempts issued across the
+	// benchmark phase (i.e. attempts beyond each request's first). It is
+	// tracked apart from LatencyHistogram, which only ever records
+	// first-attempt latency, so retries cannot silently inflate percentiles.
+	RetryCount int64 `json:"retry_count"`
+
+	// RetryLatency is a percentile -> latency-ms dump of time spent on
+	// retries (backoff sleeps plus the retried attempts themselves),
+	// populated only when at least one retry occurred.
+	RetryLatency map[string]float64 `json:"retry_latency_ms,omitempty"`
+
+	// ErrorTypes counts failed requests by their ES error.type (or
+	// context_deadline_exceeded for requests that timed out; other
+	// transport-level failures count toward ErrorCount but get no type
+	// here), so users can tell backpressure
+	// (es_rejected_execution_exception) apart from malformed queries
+	// (search_phase_execution_exception).
+	ErrorTypes map[string]int64 `json:"error_types,omitempty"`
+
+	// Samples is the cluster-state time series collected during the
+	// benchmark phase by a ClusterSampler, for correlating latency spikes
+	// with GC pauses, thread-pool rejections, etc. Populated only when
+	// --sample-interval > 0.
+	Samples                  []Sample `json:"samples,omitempty"`
+	PeakSearchQueue          int64    `json:"peak_search_queue,omitempty"`
+	TotalGCPauseMs           int64    `json:"total_gc_pause_ms,omitempty"`
+	SearchRejectionsOccurred bool     `json:"search_rejections_occurred,omitempty"`
+}
+
+// RetryPolicy controls how sendQuery retries requests that fail with a
+// transient error. Backoff before attempt N (0-indexed, N>=1) is
+// min(MaxBackoff, InitialBackoff * Multiplier^(N-1)), perturbed by up to
+// ±JitterFraction drawn from the calling worker's RNG.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	JitterFraction float64
+}
+
+// BenchmarkOutput is the top-level shape written to --output: the base
+// search benchmark plus one Result per requested query mutator, keyed by
+// mutator name.
+type BenchmarkOutput struct {
+	Base     *Result            `json:"base"`
+	Mutators map[string]*Result `json:"mutators,omitempty"`
+}
+
+// LoadModel selects how requests are scheduled onto the worker pool.
+type LoadModel string
+
+const (
+	// LoadModelClosed is the original behavior: each worker immediately
+	// issues its next request as soon as the previous one completes.
+	LoadModelClosed LoadModel = "closed"
+	// LoadModelConstant emits requests at a fixed 1/rps interval regardless
+	// of how long prior requests take to complete (open loop).
+	LoadModelConstant LoadModel = "constant"
+	// LoadModelPoisson emits requests with exponentially distributed
+	// inter-arrival times at the given mean rate (open loop).
+	LoadModelPoisson LoadModel = "poisson"
+)
+
+func parseLoadModel(s string) (LoadModel, error) {
+	switch LoadModel(s) {
+	case LoadModelClosed, LoadModelConstant, LoadModelPoisson:
+		return LoadModel(s), nil
+	default:
+		return "", fmt.Errorf("unknown --load-model %q (expected closed, constant, or poisson)", s)
+	}
+}
+
+// BenchConfig bundles the knobs that shape a warmup+benchmark run.
+type BenchConfig struct {
+	Concurrency      int
+	WarmupRequests   int
+	TotalRequests    int
+	TimeoutMs        int
+	Seed             int64
+	ProgressInterval time.Duration
+	LoadModel        LoadModel
+	TargetRPS        float64
+	RPSTolerance     float64
+	RetryPolicy      RetryPolicy
+	SampleInterval   time.Duration
+	SampleIndex      string
+}
+
+// PhaseConfig bundles the knobs that shape a single runPhase call.
+type PhaseConfig struct {
+	Concurrency  int
+	NumRequests  int
+	TimeoutMs    int
+	LoadModel    LoadModel
+	TargetRPS    float64
+	RPSTolerance float64
+	RetryPolicy  RetryPolicy
+}
+
+// latencyHistogramPercentiles are the percentiles embedded in every Result's
+// LatencyHistogram dump.
+var latencyHistogramPercentiles = []float64{50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+// newLatencyHistogram builds a Histogram sized for latencies up to a minute,
+// recorded in microseconds, with 3 significant decimal digits of resolution.
+func newLatencyHistogram() *Histogram {
+	return NewHistogram(1, 60_000_000, 3)
 }
 
 func loadQueries(filename string) ([]Query, error) {
@@ -53,70 +180,73 @@ func loadQueries(filename string) ([]Query, error) {
 	return queries, nil
 }
 
-func percentile(values []float64, p float64) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-	sort.Float64s(values)
-	idx := int(math.Ceil(float64(len(values)) * p / 100.0))
-	if idx > len(values) {
-		idx = len(values)
-	}
-	if idx == 0 {
-		idx = 1
-	}
-	return values[idx-1]
-}
-
-func runBenchmark(
-	client *elasticsearch.Client,
-	queries []Query,
-	concurrency int,
-	warmupRequests int,
-	totalRequests int,
-	timeoutMs int,
-	seed int64,
-) (*Result, error) {
-	rng := rand.New(rand.NewSource(seed))
-	var latencies []float64
-	var latenciesMutex sync.Mutex
+func runBenchmark(client *elasticsearch.Client, queries []Query, cfg BenchConfig) (*Result, error) {
+	rng := rand.New(rand.NewSource(cfg.Seed))
 
 	var successCount int64
 	var errorCount int64
 
-	// Warmup phase
-	fmt.Printf("Warmup phase: %d requests...\n", warmupRequests)
-	err := runPhase(client, queries, concurrency, warmupRequests, timeoutMs, rng, nil)
+	// Warmup phase always runs closed-loop: its only purpose is to warm
+	// caches/connections, not to exercise the configured load model.
+	fmt.Printf("Warmup phase: %d requests...\n", cfg.WarmupRequests)
+	err := runPhase(client, queries, PhaseConfig{
+		Concurrency: cfg.Concurrency,
+		NumRequests: cfg.WarmupRequests,
+		TimeoutMs:   cfg.TimeoutMs,
+		LoadModel:   LoadModelClosed,
+		RetryPolicy: cfg.RetryPolicy,
+	}, rng, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// Benchmark phase
-	fmt.Printf("Benchmark phase: %d requests...\n", totalRequests)
+	fmt.Printf("Benchmark phase: %d requests (load-model=%s)...\n", cfg.TotalRequests, cfg.LoadModel)
 	startTime := time.Now()
 
-	latencies = make([]float64, 0, totalRequests)
-	err = runPhase(client, queries, concurrency, totalRequests, timeoutMs, rng, &PhaseResults{
-		latencies:    &latencies,
-		latenciesMu:  &latenciesMutex,
-		successCount: &successCount,
-		errorCount:   &errorCount,
-	})
+	histogram := newLatencyHistogram()
+	schedulingDelay := newLatencyHistogram()
+	retryLatency := newLatencyHistogram()
+	var retryCount int64
+	phaseResults := &PhaseResults{
+		histogram:       histogram,
+		schedulingDelay: schedulingDelay,
+		retryLatency:    retryLatency,
+		successCount:    &successCount,
+		errorCount:      &errorCount,
+		retryCount:      &retryCount,
+		errorTypes:      make(map[string]int64),
+	}
+
+	var sampler *ClusterSampler
+	var stopSampler context.CancelFunc
+	if cfg.SampleInterval > 0 {
+		sampler = newClusterSampler(client, cfg.SampleIndex, cfg.SampleInterval)
+		var samplerCtx context.Context
+		samplerCtx, stopSampler = context.WithCancel(context.Background())
+		go sampler.run(samplerCtx, startTime)
+	}
+
+	stopProgress := startProgressReporter(phaseResults, startTime, cfg.ProgressInterval)
+	err = runPhase(client, queries, PhaseConfig{
+		Concurrency:  cfg.Concurrency,
+		NumRequests:  cfg.TotalRequests,
+		TimeoutMs:    cfg.TimeoutMs,
+		LoadModel:    cfg.LoadModel,
+		TargetRPS:    cfg.TargetRPS,
+		RPSTolerance: cfg.RPSTolerance,
+		RetryPolicy:  cfg.RetryPolicy,
+	}, rng, phaseResults)
+	stopProgress()
+	if stopSampler != nil {
+		stopSampler()
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	elapsedSec := time.Since(startTime).Seconds()
 
-	// Calculate metrics
-	avgLat := 0.0
-	for _, lat := range latencies {
-		avgLat += lat
-	}
-	avgLat /= float64(len(latencies))
-
-	p95 := percentile(latencies, 95)
-	p99 := percentile(latencies, 99)
 	throughput := float64(successCount) / elapsedSec
 
 	totalReq := successCount + errorCount
@@ -126,94 +256,335 @@ func runBenchmark(
 	}
 
 	result := &Result{
-		AvgLatencyMs:      avgLat,
-		P95LatencyMs:      p95,
-		P99LatencyMs:      p99,
+		AvgLatencyMs:      histogram.Mean() / 1000,
+		P50LatencyMs:      float64(histogram.ValueAtPercentile(50)) / 1000,
+		P95LatencyMs:      float64(histogram.ValueAtPercentile(95)) / 1000,
+		P99LatencyMs:      float64(histogram.ValueAtPercentile(99)) / 1000,
+		P999LatencyMs:     float64(histogram.ValueAtPercentile(99.9)) / 1000,
 		ThroughputReqSec:  throughput,
 		SuccessCount:      successCount,
 		ErrorCount:        errorCount,
 		ErrorRate:         errorRate,
 		ElapsedSeconds:    elapsedSec,
-		WarmupRequests:    warmupRequests,
-		BenchmarkRequests: totalRequests,
+		WarmupRequests:    cfg.WarmupRequests,
+		BenchmarkRequests: cfg.TotalRequests,
+		LatencyHistogram:  microsPercentilesToMs(histogram, latencyHistogramPercentiles),
+		LoadModel:         string(cfg.LoadModel),
+		TargetRPS:         cfg.TargetRPS,
+		RetryCount:        retryCount,
+	}
+
+	if cfg.LoadModel != LoadModelClosed {
+		result.SchedulingDelay = microsPercentilesToMs(schedulingDelay, latencyHistogramPercentiles)
+	}
+	if retryCount > 0 {
+		result.RetryLatency = microsPercentilesToMs(retryLatency, latencyHistogramPercentiles)
+	}
+	if len(phaseResults.errorTypes) > 0 {
+		result.ErrorTypes = phaseResults.errorTypes
+	}
+
+	if sampler != nil {
+		peakSearchQueue, totalGCPauseMs, rejectionsOccurred, samples := sampler.summary()
+		result.Samples = samples
+		result.PeakSearchQueue = peakSearchQueue
+		result.TotalGCPauseMs = totalGCPauseMs
+		result.SearchRejectionsOccurred = rejectionsOccurred
+
+		fmt.Printf("───────────────────────────────────────\n")
+		fmt.Printf("Peak search queue:    %d\n", peakSearchQueue)
+		fmt.Printf("Total GC pause:       %d ms\n", totalGCPauseMs)
+		fmt.Printf("Search rejections:    %v\n", rejectionsOccurred)
 	}
 
 	return result, nil
 }
 
-// PhaseResults holds mutable results during a phase
+func microsPercentilesToMs(h *Histogram, percentiles []float64) map[string]float64 {
+	dump := make(map[string]float64, len(percentiles))
+	for key, micros := range h.PercentileDump(percentiles) {
+		dump[key] = float64(micros) / 1000
+	}
+	return dump
+}
+
+// startProgressReporter launches a background goroutine that prints rolling
+// throughput and latency percentiles every interval until the returned stop
+// func is called. A zero or negative interval disables reporting.
+func startProgressReporter(results *PhaseResults, startTime time.Time, interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				printProgress(results, startTime)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func printProgress(results *PhaseResults, startTime time.Time) {
+	elapsed := time.Since(startTime).Seconds()
+	success := atomic.LoadInt64(results.successCount)
+	errs := atomic.LoadInt64(results.errorCount)
+	rps := 0.0
+	if elapsed > 0 {
+		rps = float64(success) / elapsed
+	}
+
+	fmt.Printf(
+		"[%6.1fs] %8.1f req/s  p50=%.1fms  p95=%.1fms  p99=%.1fms  success=%d  errors=%d\n",
+		elapsed,
+		rps,
+		float64(results.histogram.ValueAtPercentile(50))/1000,
+		float64(results.histogram.ValueAtPercentile(95))/1000,
+		float64(results.histogram.ValueAtPercentile(99))/1000,
+		success,
+		errs,
+	)
+}
+
+// PhaseResults holds the mutable, concurrently-updated state accumulated
+// during a phase. The histograms are themselves lock-free, so the only
+// shared writes on the hot path needing a lock are errorTypes.
 type PhaseResults struct {
-	latencies    *[]float64
-	latenciesMu  *sync.Mutex
-	successCount *int64
-	errorCount   *int64
+	histogram       *Histogram
+	schedulingDelay *Histogram
+	retryLatency    *Histogram
+	successCount    *int64
+	errorCount      *int64
+	retryCount      *int64
+
+	errorTypesMu sync.Mutex
+	errorTypes   map[string]int64
+}
+
+// requestToken is what the scheduler hands to a worker. intendedStart is the
+// zero Time in closed-loop mode (no independent schedule to measure against);
+// in open-loop mode it is the time the request was meant to fire, so workers
+// can correct for coordinated omission.
+type requestToken struct {
+	intendedStart time.Time
 }
 
 func runPhase(
 	client *elasticsearch.Client,
 	queries []Query,
-	concurrency int,
-	numRequests int,
-	timeoutMs int,
+	cfg PhaseConfig,
 	rng *rand.Rand,
 	results *PhaseResults,
 ) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
+	phaseStart := time.Now()
 
 	var wg sync.WaitGroup
-	requestChan := make(chan int, concurrency)
 
-	// Worker pool - each worker gets its own RNG
-	for i := 0; i < concurrency; i++ {
+	// Closed-loop feeding is backpressured by a channel sized to the worker
+	// pool, same as before. Open-loop feeding must not block on worker
+	// availability - a slow worker pool should show up as scheduling delay,
+	// not as a throttled scheduler - so it gets a generously buffered
+	// channel instead.
+	chanSize := cfg.Concurrency
+	if cfg.LoadModel != LoadModelClosed {
+		chanSize = cfg.Concurrency * 8
+		if chanSize < 1024 {
+			chanSize = 1024
+		}
+	}
+	requestChan := make(chan requestToken, chanSize)
+
+	// Worker pool - each worker gets its own RNG. Latencies are recorded
+	// straight into the shared, lock-free results.histogram (see Histogram's
+	// doc comment) rather than a per-worker histogram merged at the end, so
+	// the progress reporter's percentiles are live throughout the phase
+	// instead of jumping from zero to final only after the last worker exits.
+	for i := 0; i < cfg.Concurrency; i++ {
 		wg.Add(1)
 		workerID := int64(i)
 		workerRNG := rand.New(rand.NewSource(rng.Int63() + workerID))
 
 		go func(workerRNG *rand.Rand) {
 			defer wg.Done()
-			for range requestChan {
+			for tok := range requestChan {
+				actualStart := time.Now()
+
 				// Pick random query using worker's own RNG
 				query := queries[workerRNG.Intn(len(queries))]
 
-				// Send request and measure latency
-				start := time.Now()
-				statusCode, err := sendQuery(client, &query, time.Duration(timeoutMs)*time.Millisecond)
-				elapsed := time.Since(start)
+				outcome, err := sendQuery(client, &query, time.Duration(cfg.TimeoutMs)*time.Millisecond, cfg.RetryPolicy, workerRNG)
 
 				if results != nil {
-					if err != nil || statusCode < 200 || statusCode >= 300 {
+					if outcome.RetryCount > 0 {
+						atomic.AddInt64(results.retryCount, int64(outcome.RetryCount))
+						results.retryLatency.Record(outcome.RetryLatency.Microseconds())
+					}
+
+					if err != nil || outcome.StatusCode < 200 || outcome.StatusCode >= 300 {
 						atomic.AddInt64(results.errorCount, 1)
-					} else {
-						atomic.AddInt64(results.successCount, 1)
-						latMs := elapsed.Seconds() * 1000
-						results.latenciesMu.Lock()
-						*results.latencies = append(*results.latencies, latMs)
-						results.latenciesMu.Unlock()
+						if outcome.ErrorType != "" {
+							results.errorTypesMu.Lock()
+							results.errorTypes[outcome.ErrorType]++
+							results.errorTypesMu.Unlock()
+						}
+						continue
+					}
+					atomic.AddInt64(results.successCount, 1)
+
+					if tok.intendedStart.IsZero() {
+						results.histogram.Record(outcome.FirstAttemptLatency.Microseconds())
+						continue
 					}
+
+					schedulingDelay := actualStart.Sub(tok.intendedStart)
+					if schedulingDelay < 0 {
+						schedulingDelay = 0
+					}
+					results.schedulingDelay.Record(schedulingDelay.Microseconds())
+					// Coordinated-omission-corrected latency: how long a
+					// caller submitting at the intended rate would actually
+					// have waited for this response.
+					results.histogram.Record((schedulingDelay + outcome.FirstAttemptLatency).Microseconds())
 				}
 			}
 		}(workerRNG)
 	}
 
-	// Feed requests
+	var feedErr error
+	if cfg.LoadModel == LoadModelClosed {
+		feedErr = feedClosedLoop(ctx, requestChan, cfg.NumRequests)
+	} else {
+		feedErr = feedOpenLoop(ctx, requestChan, cfg, rng)
+	}
+	close(requestChan)
+	wg.Wait()
+
+	if feedErr != nil {
+		return feedErr
+	}
+	return checkAchievedRPS(cfg, results, phaseStart)
+}
+
+// feedClosedLoop pushes one token per request, blocking on requestChan so
+// the scheduler never gets ahead of the worker pool.
+func feedClosedLoop(ctx context.Context, requestChan chan<- requestToken, numRequests int) error {
 	for i := 0; i < numRequests; i++ {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case requestChan <- i:
+		case requestChan <- requestToken{}:
 		}
 	}
-	close(requestChan)
+	return nil
+}
+
+// feedOpenLoop emits tokens at times drawn from the configured arrival
+// process, independent of how quickly the worker pool drains them. Sends
+// happen off a separate goroutine per token so a momentarily saturated
+// worker pool delays delivery (which shows up as scheduling delay) instead
+// of delaying the next scheduled fire time.
+func feedOpenLoop(ctx context.Context, requestChan chan<- requestToken, cfg PhaseConfig, rng *rand.Rand) error {
+	interval := time.Duration(float64(time.Second) / cfg.TargetRPS)
+
+	// senderWG tracks in-flight send() goroutines so the caller can safely
+	// close requestChan only after every one of them has either delivered
+	// its token or given up on ctx.Done().
+	var senderWG sync.WaitGroup
+	defer senderWG.Wait()
+
+	send := func(intended time.Time) {
+		senderWG.Add(1)
+		go func() {
+			defer senderWG.Done()
+			select {
+			case requestChan <- requestToken{intendedStart: intended}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	switch cfg.LoadModel {
+	case LoadModelConstant:
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for i := 0; i < cfg.NumRequests; i++ {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case fireTime := <-ticker.C:
+				send(fireTime)
+			}
+		}
+	case LoadModelPoisson:
+		nextFire := time.Now()
+		for i := 0; i < cfg.NumRequests; i++ {
+			u := rng.Float64()
+			for u <= 0 {
+				u = rng.Float64()
+			}
+			gap := time.Duration(-math.Log(1-u) / cfg.TargetRPS * float64(time.Second))
+			nextFire = nextFire.Add(gap)
+
+			if sleep := time.Until(nextFire); sleep > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(sleep):
+				}
+			}
+			send(nextFire)
+		}
+	default:
+		return fmt.Errorf("feedOpenLoop: unsupported load model %q", cfg.LoadModel)
+	}
 
-	wg.Wait()
+	return nil
+}
+
+// checkAchievedRPS fails the phase if the completed throughput fell short of
+// the target rate by more than the configured tolerance - a sign the
+// cluster could not keep up and the scheduling-delay-corrected latencies are
+// no longer a meaningful open-loop measurement.
+func checkAchievedRPS(cfg PhaseConfig, results *PhaseResults, phaseStart time.Time) error {
+	if cfg.LoadModel == LoadModelClosed || cfg.TargetRPS <= 0 || results == nil {
+		return nil
+	}
+
+	elapsed := time.Since(phaseStart).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+
+	tolerance := cfg.RPSTolerance
+	if tolerance <= 0 {
+		tolerance = 0.1
+	}
+
+	achieved := float64(atomic.LoadInt64(results.successCount)) / elapsed
+	minAcceptable := cfg.TargetRPS * (1 - tolerance)
+	if achieved < minAcceptable {
+		return fmt.Errorf(
+			"achieved %.1f req/s, more than %.0f%% below target %.1f req/s - cluster could not keep up with the open-loop schedule",
+			achieved, tolerance*100, cfg.TargetRPS,
+		)
+	}
 	return nil
 }
 
 func extractFieldsFromQuery(query *Query) []string {
 	// Extract field names from query filters
 	fields := make(map[string]bool)
-	
+
 	if body, ok := query.Body["query"].(map[string]interface{}); ok {
 		if boolQuery, ok := body["bool"].(map[string]interface{}); ok {
 			if filterList, ok := boolQuery["filter"].([]interface{}); ok {
@@ -234,7 +605,7 @@ func extractFieldsFromQuery(query *Query) []string {
 			}
 		}
 	}
-	
+
 	var result []string
 	for field := range fields {
 		result = append(result, field)
@@ -242,116 +613,181 @@ func extractFieldsFromQuery(query *Query) []string {
 	return result
 }
 
-func mutateQueryWithAgg(query *Query, rng *rand.Rand) *Query {
-	// Create a copy of query with random terms aggregation
-	mutated := *query
-	mutated.Body = make(map[string]interface{})
-	
-	// Copy existing body fields
-	for k, v := range query.Body {
-		mutated.Body[k] = v
-	}
-	
-	// Extract fields from query filters
-	fields := extractFieldsFromQuery(query)
-	if len(fields) == 0 {
-		return &mutated
-	}
-	
-	// Pick random field
-	selectedField := fields[rng.Intn(len(fields))]
-	
-	// Determine actual field path (with "data." for flattened)
-	fieldPath := selectedField
-	if query.Index == "bench_flattened" {
-		fieldPath = "data." + selectedField
-	}
-	
-	// Add aggregation
-	mutated.Body["aggs"] = map[string]interface{}{
-		"field_values": map[string]interface{}{
-			"terms": map[string]interface{}{
-				"field": fieldPath,
-				"size":  10,
-			},
-		},
-	}
-	
-	return &mutated
+// queryAttemptResult captures the outcome of a sendQuery call. FirstAttemptLatency
+// and RetryLatency are kept separate so a caller can record only the former
+// into its latency histogram, keeping percentiles interpretable even when
+// retries occurred.
+type queryAttemptResult struct {
+	StatusCode          int
+	FirstAttemptLatency time.Duration
+	RetryLatency        time.Duration
+	RetryCount          int
+	ErrorType           string
+	ErrorReason         string
 }
 
-func mutateQueries(queries []Query, rng *rand.Rand) []Query {
-	// Mutate all queries by adding aggregations
-	mutated := make([]Query, len(queries))
-	for i, q := range queries {
-		mutated[i] = *mutateQueryWithAgg(&q, rng)
+// sendQuery issues query against client, retrying on HTTP 429/502/503/504
+// and on the request timing out, per policy. The error and status code of
+// the final attempt are returned; retry bookkeeping is always populated,
+// even when the query ultimately succeeds.
+func sendQuery(client *elasticsearch.Client, query *Query, timeout time.Duration, policy RetryPolicy, rng *rand.Rand) (*queryAttemptResult, error) {
+	bodyJSON, _ := json.Marshal(query.Body)
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
 	}
-	return mutated
-}
 
-func sendQuery(client *elasticsearch.Client, query *Query, timeout time.Duration) (int, error) {
-	bodyJSON, _ := json.Marshal(query.Body)
+	result := &queryAttemptResult{}
+	var lastErr error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		attemptStart := time.Now()
+		statusCode, errType, errReason, attemptErr := doSearchAttempt(client, query.Index, bodyJSON, timeout)
+		elapsed := time.Since(attemptStart)
+
+		if attempt == 0 {
+			result.FirstAttemptLatency = elapsed
+		} else {
+			result.RetryLatency += elapsed
+		}
+
+		result.StatusCode = statusCode
+		result.ErrorType = errType
+		result.ErrorReason = errReason
+		lastErr = attemptErr
+
+		deadlineExceeded := errors.Is(attemptErr, context.DeadlineExceeded)
+		retryable := deadlineExceeded || (attemptErr == nil && isRetryableStatus(statusCode))
+		if !retryable {
+			return result, attemptErr
+		}
+		if deadlineExceeded {
+			result.ErrorType = "context_deadline_exceeded"
+			result.ErrorReason = "request exceeded --timeout-ms"
+		}
+		if attempt == maxAttempts-1 {
+			break
+		}
+
+		result.RetryCount++
+		sleepStart := time.Now()
+		time.Sleep(backoffDuration(policy, attempt, rng))
+		result.RetryLatency += time.Since(sleepStart)
+	}
 
+	return result, lastErr
+}
+
+// doSearchAttempt issues a single (non-retried) search request, parsing the
+// ES error body when the response is a non-2xx status.
+func doSearchAttempt(client *elasticsearch.Client, index string, bodyJSON []byte, timeout time.Duration) (statusCode int, errType, errReason string, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	resp, err := client.Search(
 		client.Search.WithContext(ctx),
-		client.Search.WithIndex(query.Index),
+		client.Search.WithIndex(index),
 		client.Search.WithBody(bytes.NewReader(bodyJSON)),
 	)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer resp.Body.Close()
 
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
+		return resp.StatusCode, "", "", err
 	}
 
-	statusCode := resp.StatusCode
-	io.ReadAll(resp.Body)
-	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		errType, errReason = parseESError(data)
+		return resp.StatusCode, errType, errReason, nil
+	}
 
-	return statusCode, nil
+	return resp.StatusCode, "", "", nil
 }
 
-func main() {
-	var (
-		esURL          = flag.String("es-url", "http://localhost:9200", "Elasticsearch URL")
-		queriesFile    = flag.String("queries-file", "", "Queries JSON file")
-		concurrency    = flag.Int("concurrency", 32, "Number of concurrent workers")
-		warmupRequests = flag.Int("warmup-requests", 5000, "Warmup requests")
-		totalRequests  = flag.Int("total-requests", 100000, "Total benchmark requests")
-		timeoutMs      = flag.Int("timeout-ms", 2000, "Request timeout in milliseconds")
-		seed           = flag.Int64("seed", 42, "Random seed")
-		outputFile     = flag.String("output", "results.json", "Output JSON file")
-		benchmarkAggs  = flag.Bool("benchmark-aggs", false, "Also benchmark with aggregations")
-	)
-	flag.Parse()
+// parseESError extracts error.type/error.reason from an ES error response
+// body, falling back to a synthetic "unknown_error" type when the body
+// doesn't match the expected shape.
+func parseESError(body []byte) (errType, errReason string) {
+	var parsed struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error.Type == "" {
+		return "unknown_error", parsed.Error.Reason
+	}
+	return parsed.Error.Type, parsed.Error.Reason
+}
 
-	if *queriesFile == "" {
-		fmt.Fprintf(os.Stderr, "ERROR: --queries-file is required\n")
+// isRetryableStatus reports whether statusCode represents transient
+// backpressure (thread pool rejection, gateway timeout, etc.) worth retrying,
+// as opposed to a malformed query that will fail identically every attempt.
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 429, 502, 503, 504:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoffDuration computes the sleep before retry attempt attempt+1:
+// min(MaxBackoff, InitialBackoff*Multiplier^attempt), perturbed by up to
+// ±JitterFraction so many workers retrying at once don't all wake in
+// lockstep.
+func backoffDuration(policy RetryPolicy, attempt int, rng *rand.Rand) time.Duration {
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.Multiplier, float64(attempt))
+	if maxBackoff := float64(policy.MaxBackoff); backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	jitter := backoff * policy.JitterFraction * (2*rng.Float64() - 1)
+	backoff += jitter
+	if backoff < 0 {
+		backoff = 0
+	}
+
+	return time.Duration(backoff)
+}
+
+// main dispatches to the "search" or "index" subcommand. Flags are parsed
+// per-subcommand (rather than globally) so each can evolve its own flag set
+// without the other's flags leaking into `--help` output.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: flatbench <search|index> [flags]\n")
 		os.Exit(1)
 	}
 
-	// Load queries
-	queries, err := loadQueries(*queriesFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR loading queries: %v\n", err)
+	switch os.Args[1] {
+	case "search":
+		runSearchCommand(os.Args[2:])
+	case "index":
+		runIndexCommand(os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: unknown subcommand %q (expected search or index)\n", os.Args[1])
 		os.Exit(1)
 	}
-	fmt.Printf("✓ Loaded %d queries\n", len(queries))
+}
 
-	// Get auth from environment if provided
+// newESClient builds an Elasticsearch client for esURL, picking up basic-auth
+// credentials from ES_USER/ES_PASS if both are set, and verifying the
+// connection with an Info() call before returning.
+func newESClient(esURL string) (*elasticsearch.Client, error) {
 	user := os.Getenv("ES_USER")
 	pass := os.Getenv("ES_PASS")
 	if user != "" && pass != "" {
 		fmt.Printf("✓ Using authentication (ES_USER=%s)\n", user)
 	}
 
-	// Connect to ES
 	cfg := elasticsearch.Config{
-		Addresses: []string{*esURL},
+		Addresses: []string{esURL},
 	}
-
-	// Add authentication if provided
 	if user != "" && pass != "" {
 		cfg.Username = user
 		cfg.Password = pass
@@ -359,40 +795,122 @@ func main() {
 
 	client, err := elasticsearch.NewClient(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR connecting to ES: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("connecting to ES: %w", err)
 	}
 
 	info, err := client.Info()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Could not connect to Elasticsearch\n")
-		os.Exit(1)
+		return nil, fmt.Errorf("could not connect to Elasticsearch: %w", err)
 	}
 	info.Body.Close()
 	fmt.Printf("✓ Connected to Elasticsearch\n")
 
-	// Run benchmark
-	result, err := runBenchmark(
-		client,
-		queries,
-		*concurrency,
-		*warmupRequests,
-		*totalRequests,
-		*timeoutMs,
-		*seed,
+	return client, nil
+}
+
+func runSearchCommand(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var (
+		esURL           = fs.String("es-url", "http://localhost:9200", "Elasticsearch URL")
+		queriesFile     = fs.String("queries-file", "", "Queries JSON file")
+		concurrency     = fs.Int("concurrency", 32, "Number of concurrent workers")
+		warmupRequests  = fs.Int("warmup-requests", 5000, "Warmup requests")
+		totalRequests   = fs.Int("total-requests", 100000, "Total benchmark requests")
+		timeoutMs       = fs.Int("timeout-ms", 2000, "Request timeout in milliseconds")
+		seed            = fs.Int64("seed", 42, "Random seed")
+		outputFile      = fs.String("output", "results.json", "Output JSON file")
+		progressSec     = fs.Int("progress-interval", 5, "Seconds between progress reports during the benchmark phase (0 disables)")
+		loadModelFlag   = fs.String("load-model", "closed", "Load generation model: closed, constant, or poisson")
+		targetRPS       = fs.Float64("target-rps", 0, "Target requests/sec for --load-model=constant|poisson")
+		rpsTolerance    = fs.Float64("rps-tolerance", 0.1, "Fraction below --target-rps the achieved throughput may fall before failing fast")
+		mutatorsFlag    = fs.String("mutators", "", "Comma-separated query mutators to additionally benchmark: terms_agg, date_histogram, cardinality, top_hits, sort, pagination, highlight")
+		termsAggSize    = fs.Int("terms-agg-size", 10, "Bucket size for the terms_agg mutator")
+		topHitsSize     = fs.Int("top-hits-size", 3, "Hits per bucket for the top_hits mutator")
+		timestampField  = fs.String("timestamp-field", "timestamp", "Field used by the date_histogram mutator")
+		paginationMax   = fs.Int("pagination-max-from", 10000, "Maximum \"from\" offset swept by the pagination mutator")
+		paginationStep  = fs.Int("pagination-step", 1000, "Granularity of the \"from\" offsets swept by the pagination mutator")
+		maxAttempts     = fs.Int("retry-max-attempts", 3, "Maximum attempts per request (1 disables retries)")
+		initialBackoff  = fs.Int("retry-initial-backoff-ms", 100, "Backoff before the first retry, in milliseconds")
+		maxBackoff      = fs.Int("retry-max-backoff-ms", 2000, "Backoff ceiling, in milliseconds")
+		retryMultiplier = fs.Float64("retry-multiplier", 2.0, "Backoff growth factor between retries")
+		retryJitter     = fs.Float64("retry-jitter-fraction", 0.2, "Fraction of the computed backoff randomly added or subtracted")
+		sampleInterval  = fs.Duration("sample-interval", time.Second, "Interval between cluster-state samples during the benchmark phase (0 disables)")
 	)
+	fs.Parse(args)
+
+	if *queriesFile == "" {
+		fmt.Fprintf(os.Stderr, "ERROR: --queries-file is required\n")
+		os.Exit(1)
+	}
+
+	loadModel, err := parseLoadModel(*loadModelFlag)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR during benchmark: %v\n", err)
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if loadModel != LoadModelClosed && *targetRPS <= 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --target-rps must be > 0 for --load-model=%s\n", loadModel)
 		os.Exit(1)
 	}
 
-	// Write output
-	outputJSON, _ := json.MarshalIndent(result, "", "  ")
-	if err := os.WriteFile(*outputFile, outputJSON, 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR writing output: %v\n", err)
+	mutators, err := buildMutators(strings.Split(*mutatorsFlag, ","), MutatorConfig{
+		TermsAggSize:      *termsAggSize,
+		TopHitsSize:       *topHitsSize,
+		TimestampField:    *timestampField,
+		PaginationMaxFrom: *paginationMax,
+		PaginationStep:    *paginationStep,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Load queries
+	queries, err := loadQueries(*queriesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR loading queries: %v\n", err)
+		os.Exit(1)
+	}
+	if len(queries) == 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: --queries-file contains no queries\n")
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Loaded %d queries\n", len(queries))
+
+	client, err := newESClient(*esURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Run benchmark
+	progressInterval := time.Duration(*progressSec) * time.Second
+	retryPolicy := RetryPolicy{
+		MaxAttempts:    *maxAttempts,
+		InitialBackoff: time.Duration(*initialBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(*maxBackoff) * time.Millisecond,
+		Multiplier:     *retryMultiplier,
+		JitterFraction: *retryJitter,
+	}
+	benchCfg := BenchConfig{
+		Concurrency:      *concurrency,
+		WarmupRequests:   *warmupRequests,
+		TotalRequests:    *totalRequests,
+		TimeoutMs:        *timeoutMs,
+		Seed:             *seed,
+		ProgressInterval: progressInterval,
+		LoadModel:        loadModel,
+		TargetRPS:        *targetRPS,
+		RPSTolerance:     *rpsTolerance,
+		RetryPolicy:      retryPolicy,
+		SampleInterval:   *sampleInterval,
+		SampleIndex:      queries[0].Index,
+	}
+	result, err := runBenchmark(client, queries, benchCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR during benchmark: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("✓ Results written to %s\n\n", *outputFile)
 
 	// Print summary
 	fmt.Printf("═══════════════════════════════════════\n")
@@ -407,55 +925,67 @@ func main() {
 	fmt.Printf("Throughput:           %.2f req/sec\n", result.ThroughputReqSec)
 	fmt.Printf("───────────────────────────────────────\n")
 	fmt.Printf("Avg Latency:          %.2f ms\n", result.AvgLatencyMs)
+	fmt.Printf("p50 Latency:          %.2f ms\n", result.P50LatencyMs)
 	fmt.Printf("p95 Latency:          %.2f ms\n", result.P95LatencyMs)
 	fmt.Printf("p99 Latency:          %.2f ms\n", result.P99LatencyMs)
+	fmt.Printf("p999 Latency:         %.2f ms\n", result.P999LatencyMs)
+	if result.LoadModel != string(LoadModelClosed) {
+		fmt.Printf("───────────────────────────────────────\n")
+		fmt.Printf("Scheduling delay p50: %.2f ms\n", result.SchedulingDelay["p50"])
+		fmt.Printf("Scheduling delay p99: %.2f ms\n", result.SchedulingDelay["p99"])
+	}
+	if result.RetryCount > 0 {
+		fmt.Printf("───────────────────────────────────────\n")
+		fmt.Printf("Retries:              %d\n", result.RetryCount)
+		fmt.Printf("Error types:          %v\n", result.ErrorTypes)
+	}
 	fmt.Printf("═══════════════════════════════════════\n")
-	
-	// Benchmark with aggregations if requested
-	if *benchmarkAggs {
-		fmt.Printf("\n")
+
+	output := &BenchmarkOutput{Base: result}
+
+	// Benchmark each requested query mutator in turn
+	if len(mutators) > 0 {
+		output.Mutators = make(map[string]*Result, len(mutators))
 		rng := rand.New(rand.NewSource(*seed))
-		mutatedQueries := mutateQueries(queries, rng)
-		
-		fmt.Printf("Running aggregation benchmark...\n")
-		resultAgg, err := runBenchmark(
-			client,
-			mutatedQueries,
-			*concurrency,
-			*warmupRequests,
-			*totalRequests,
-			*timeoutMs,
-			*seed+1, // Different seed for agg phase
-		)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR during agg benchmark: %v\n", err)
-			os.Exit(1)
-		}
-		
-		// Write agg output
-		aggsOutputFile := strings.Replace(*outputFile, ".json", "_with_aggs.json", 1)
-		outputJSON, _ := json.MarshalIndent(resultAgg, "", "  ")
-		if err := os.WriteFile(aggsOutputFile, outputJSON, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR writing agg output: %v\n", err)
-			os.Exit(1)
+
+		for i, mutator := range mutators {
+			fmt.Printf("\nRunning %s mutator benchmark...\n", mutator.Name())
+			mutatedQueries := applyMutator(queries, mutator, rng)
+
+			mutatorCfg := benchCfg
+			mutatorCfg.Seed = *seed + 1 + int64(i) // Distinct seed per mutator
+			mutatorResult, err := runBenchmark(client, mutatedQueries, mutatorCfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR during %s mutator benchmark: %v\n", mutator.Name(), err)
+				os.Exit(1)
+			}
+			output.Mutators[mutator.Name()] = mutatorResult
+
+			fmt.Printf("═══════════════════════════════════════\n")
+			fmt.Printf("BENCHMARK RESULTS (%s)\n", mutator.Name())
+			fmt.Printf("═══════════════════════════════════════\n")
+			fmt.Printf("Requests (warmup):    %d\n", mutatorResult.WarmupRequests)
+			fmt.Printf("Requests (benchmark): %d\n", mutatorResult.BenchmarkRequests)
+			fmt.Printf("Successes:            %d\n", mutatorResult.SuccessCount)
+			fmt.Printf("Errors:               %d (%.2f%%)\n", mutatorResult.ErrorCount, mutatorResult.ErrorRate*100)
+			fmt.Printf("───────────────────────────────────────\n")
+			fmt.Printf("Elapsed:              %.2fs\n", mutatorResult.ElapsedSeconds)
+			fmt.Printf("Throughput:           %.2f req/sec\n", mutatorResult.ThroughputReqSec)
+			fmt.Printf("───────────────────────────────────────\n")
+			fmt.Printf("Avg Latency:          %.2f ms\n", mutatorResult.AvgLatencyMs)
+			fmt.Printf("p50 Latency:          %.2f ms\n", mutatorResult.P50LatencyMs)
+			fmt.Printf("p95 Latency:          %.2f ms\n", mutatorResult.P95LatencyMs)
+			fmt.Printf("p99 Latency:          %.2f ms\n", mutatorResult.P99LatencyMs)
+			fmt.Printf("p999 Latency:         %.2f ms\n", mutatorResult.P999LatencyMs)
+			fmt.Printf("═══════════════════════════════════════\n")
 		}
-		fmt.Printf("✓ Aggregation results written to %s\n\n", aggsOutputFile)
-		
-		// Print agg summary
-		fmt.Printf("═══════════════════════════════════════\n")
-		fmt.Printf("BENCHMARK RESULTS (Search + Aggs)\n")
-		fmt.Printf("═══════════════════════════════════════\n")
-		fmt.Printf("Requests (warmup):    %d\n", resultAgg.WarmupRequests)
-		fmt.Printf("Requests (benchmark): %d\n", resultAgg.BenchmarkRequests)
-		fmt.Printf("Successes:            %d\n", resultAgg.SuccessCount)
-		fmt.Printf("Errors:               %d (%.2f%%)\n", resultAgg.ErrorCount, resultAgg.ErrorRate*100)
-		fmt.Printf("───────────────────────────────────────\n")
-		fmt.Printf("Elapsed:              %.2fs\n", resultAgg.ElapsedSeconds)
-		fmt.Printf("Throughput:           %.2f req/sec\n", resultAgg.ThroughputReqSec)
-		fmt.Printf("───────────────────────────────────────\n")
-		fmt.Printf("Avg Latency:          %.2f ms\n", resultAgg.AvgLatencyMs)
-		fmt.Printf("p95 Latency:          %.2f ms\n", resultAgg.P95LatencyMs)
-		fmt.Printf("p99 Latency:          %.2f ms\n", resultAgg.P99LatencyMs)
-		fmt.Printf("═══════════════════════════════════════\n")
 	}
+
+	// Write combined output
+	outputJSON, _ := json.MarshalIndent(output, "", "  ")
+	if err := os.WriteFile(*outputFile, outputJSON, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR writing output: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n✓ Results written to %s\n", *outputFile)
 }
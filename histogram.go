@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Histogram is a lock-free, log-linear latency histogram loosely modeled on
+// HdrHistogram: values are tracked with full resolution up to subBucketCount
+// and then with exponentially decreasing relative resolution (still bounded
+// by sigFigs significant decimal digits) for larger values. Record is O(1)
+// and allocation-free, so it is safe to call from the hot path of every
+// worker goroutine without a mutex.
+type Histogram struct {
+	lowestTrackableValue        int64
+	highestTrackableValue       int64
+	unitMagnitude               int
+	subBucketHalfCountMagnitude int
+	subBucketHalfCount          int
+	subBucketCount              int
+	subBucketMask               int64
+	bucketCount                 int
+
+	counts     []int64
+	totalCount int64
+	sumValue   int64
+	minValue   int64
+	maxValue   int64
+}
+
+// NewHistogram builds a Histogram able to record values in
+// [minValueMicros, maxValueMicros] with sigFigs significant decimal digits
+// of resolution.
+func NewHistogram(minValueMicros, maxValueMicros int64, sigFigs int) *Histogram {
+	if minValueMicros < 1 {
+		minValueMicros = 1
+	}
+
+	largestValueWithSingleUnitResolution := 2 * math.Pow10(sigFigs)
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(largestValueWithSingleUnitResolution)))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	if subBucketHalfCountMagnitude < 1 {
+		subBucketHalfCountMagnitude = 1
+	}
+	unitMagnitude := int(math.Log2(float64(minValueMicros)))
+	if unitMagnitude < 0 {
+		unitMagnitude = 0
+	}
+	subBucketCount := 1 << uint(subBucketHalfCountMagnitude+1)
+	subBucketHalfCount := subBucketCount / 2
+	subBucketMask := int64(subBucketCount-1) << uint(unitMagnitude)
+
+	// Grow the bucket count until the top sub-bucket of the last bucket
+	// covers maxValueMicros.
+	bucketCount := 1
+	smallestUntrackableValue := int64(subBucketCount) << uint(unitMagnitude)
+	for smallestUntrackableValue < maxValueMicros {
+		smallestUntrackableValue <<= 1
+		bucketCount++
+	}
+
+	countsLen := (bucketCount + 1) * subBucketHalfCount
+
+	return &Histogram{
+		lowestTrackableValue:        minValueMicros,
+		highestTrackableValue:       maxValueMicros,
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketHalfCount:          subBucketHalfCount,
+		subBucketCount:              subBucketCount,
+		subBucketMask:               subBucketMask,
+		bucketCount:                 bucketCount,
+		counts:                      make([]int64, countsLen),
+		minValue:                    math.MaxInt64,
+		maxValue:                    0,
+	}
+}
+
+func (h *Histogram) bucketIndexOf(value int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(value)|uint64(h.subBucketMask))
+	return pow2Ceiling - h.unitMagnitude - (h.subBucketHalfCountMagnitude + 1)
+}
+
+func (h *Histogram) subBucketIndexOf(value int64, bucketIndex int) int {
+	return int(value >> uint(bucketIndex+h.unitMagnitude))
+}
+
+func (h *Histogram) countsIndex(bucketIndex, subBucketIndex int) int {
+	bucketBaseIndex := (bucketIndex + 1) << uint(h.subBucketHalfCountMagnitude)
+	offsetInBucket := subBucketIndex - h.subBucketHalfCount
+	return bucketBaseIndex + offsetInBucket
+}
+
+func (h *Histogram) valueFromIndex(bucketIndex, subBucketIndex int) int64 {
+	return int64(subBucketIndex) << uint(bucketIndex+h.unitMagnitude)
+}
+
+// Record adds valueMicros to the histogram. Values above the configured
+// maximum are clamped into the top bucket rather than dropped, so tail
+// percentiles stay conservative instead of silently undercounting.
+func (h *Histogram) Record(valueMicros int64) {
+	if valueMicros < h.lowestTrackableValue {
+		valueMicros = h.lowestTrackableValue
+	}
+	if valueMicros > h.highestTrackableValue {
+		valueMicros = h.highestTrackableValue
+	}
+
+	bucketIndex := h.bucketIndexOf(valueMicros)
+	subBucketIndex := h.subBucketIndexOf(valueMicros, bucketIndex)
+	idx := h.countsIndex(bucketIndex, subBucketIndex)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(h.counts) {
+		idx = len(h.counts) - 1
+	}
+
+	atomic.AddInt64(&h.counts[idx], 1)
+	atomic.AddInt64(&h.totalCount, 1)
+	atomic.AddInt64(&h.sumValue, valueMicros)
+
+	for {
+		cur := atomic.LoadInt64(&h.minValue)
+		if valueMicros >= cur || atomic.CompareAndSwapInt64(&h.minValue, cur, valueMicros) {
+			break
+		}
+	}
+	for {
+		cur := atomic.LoadInt64(&h.maxValue)
+		if valueMicros <= cur || atomic.CompareAndSwapInt64(&h.maxValue, cur, valueMicros) {
+			break
+		}
+	}
+}
+
+// Merge folds other's counts into h. The two histograms must have been
+// constructed with the same parameters (as every per-worker Histogram in
+// this package is).
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil {
+		return
+	}
+	for i, c := range other.counts {
+		if c != 0 {
+			atomic.AddInt64(&h.counts[i], c)
+		}
+	}
+	atomic.AddInt64(&h.totalCount, atomic.LoadInt64(&other.totalCount))
+	atomic.AddInt64(&h.sumValue, atomic.LoadInt64(&other.sumValue))
+
+	otherMin := atomic.LoadInt64(&other.minValue)
+	for {
+		cur := atomic.LoadInt64(&h.minValue)
+		if otherMin >= cur || atomic.CompareAndSwapInt64(&h.minValue, cur, otherMin) {
+			break
+		}
+	}
+	otherMax := atomic.LoadInt64(&other.maxValue)
+	for {
+		cur := atomic.LoadInt64(&h.maxValue)
+		if otherMax <= cur || atomic.CompareAndSwapInt64(&h.maxValue, cur, otherMax) {
+			break
+		}
+	}
+}
+
+// ValueAtPercentile returns the representative value (in the same units
+// passed to Record) of the bucket at percentile p (0-100].
+func (h *Histogram) ValueAtPercentile(p float64) int64 {
+	total := atomic.LoadInt64(&h.totalCount)
+	if total == 0 {
+		return 0
+	}
+	if p > 100 {
+		p = 100
+	}
+
+	target := int64(math.Ceil(p / 100.0 * float64(total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var cumulative int64
+	for idx := range h.counts {
+		c := atomic.LoadInt64(&h.counts[idx])
+		if c == 0 {
+			continue
+		}
+		cumulative += c
+		if cumulative >= target {
+			bucketIndex := (idx >> uint(h.subBucketHalfCountMagnitude)) - 1
+			subBucketIndex := idx - ((bucketIndex + 1) << uint(h.subBucketHalfCountMagnitude)) + h.subBucketHalfCount
+			if bucketIndex < 0 {
+				// The lower half of bucket 0 sits below the first bucket base.
+				subBucketIndex -= h.subBucketHalfCount
+				bucketIndex = 0
+			}
+			return h.valueFromIndex(bucketIndex, subBucketIndex)
+		}
+	}
+	return atomic.LoadInt64(&h.maxValue)
+}
+
+// Min returns the smallest recorded value, or 0 if nothing has been recorded.
+func (h *Histogram) Min() int64 {
+	if atomic.LoadInt64(&h.totalCount) == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.minValue)
+}
+
+// Max returns the largest recorded value, or 0 if nothing has been recorded.
+func (h *Histogram) Max() int64 {
+	return atomic.LoadInt64(&h.maxValue)
+}
+
+// Mean returns the arithmetic mean of all recorded values.
+func (h *Histogram) Mean() float64 {
+	total := atomic.LoadInt64(&h.totalCount)
+	if total == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&h.sumValue)) / float64(total)
+}
+
+// TotalCount returns the number of values recorded.
+func (h *Histogram) TotalCount() int64 {
+	return atomic.LoadInt64(&h.totalCount)
+}
+
+// PercentileDump returns a percentile -> value (in the same units passed to
+// Record) map suitable for embedding in JSON output. Only the requested
+// percentiles are included, not the underlying bucket counts.
+func (h *Histogram) PercentileDump(percentiles []float64) map[string]int64 {
+	dump := make(map[string]int64, len(percentiles))
+	for _, p := range percentiles {
+		dump[formatPercentileKey(p)] = h.ValueAtPercentile(p)
+	}
+	return dump
+}
+
+func formatPercentileKey(p float64) string {
+	return fmt.Sprintf("p%g", p)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ndjsonDoc is one line of a --docs-file corpus: the document body to index,
+// plus an optional explicit _id pulled out of the source document.
+type ndjsonDoc struct {
+	id   string
+	body []byte
+}
+
+// bulkBatch is a group of documents sent as a single _bulk request.
+type bulkBatch struct {
+	docs []ndjsonDoc
+}
+
+// IndexTelemetry is a snapshot of index-level state relevant to ingest cost,
+// collected via _cat/indices and _<index>/_stats before and after an
+// index run so the two can be diffed into a delta.
+type IndexTelemetry struct {
+	StoreSizeBytes     int64 `json:"store_size_bytes"`
+	SegmentCount       int64 `json:"segment_count"`
+	MergesTotalTimeMs  int64 `json:"merges_total_time_ms"`
+	RefreshTotalTimeMs int64 `json:"refresh_total_time_ms"`
+}
+
+// delta returns t - before, field by field.
+func (t *IndexTelemetry) delta(before *IndexTelemetry) *IndexTelemetry {
+	return &IndexTelemetry{
+		StoreSizeBytes:     t.StoreSizeBytes - before.StoreSizeBytes,
+		SegmentCount:       t.SegmentCount - before.SegmentCount,
+		MergesTotalTimeMs:  t.MergesTotalTimeMs - before.MergesTotalTimeMs,
+		RefreshTotalTimeMs: t.RefreshTotalTimeMs - before.RefreshTotalTimeMs,
+	}
+}
+
+// IndexResult holds the results of a `flatbench index` run.
+type IndexResult struct {
+	DocsIndexed  int64 `json:"docs_indexed"`
+	DocsFailed   int64 `json:"docs_failed"`
+	BulkRequests int64 `json:"bulk_requests"`
+
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	DocsPerSec     float64 `json:"docs_per_sec"`
+	BytesPerSec    float64 `json:"bytes_per_sec"`
+
+	P50BulkLatencyMs float64 `json:"p50_bulk_latency_ms"`
+	P95BulkLatencyMs float64 `json:"p95_bulk_latency_ms"`
+	P99BulkLatencyMs float64 `json:"p99_bulk_latency_ms"`
+
+	// BulkLatencyHistogram is a percentile -> latency-ms dump of the full
+	// merged per-bulk-request histogram, same shape as Result.LatencyHistogram.
+	BulkLatencyHistogram map[string]float64 `json:"bulk_latency_histogram_ms"`
+
+	// ItemErrorTypes counts failed bulk items by their ES error.type, so a
+	// run dominated by e.g. "version_conflict_engine_exception" is
+	// distinguishable from one dominated by mapping errors.
+	ItemErrorTypes map[string]int64 `json:"item_error_types,omitempty"`
+
+	TelemetryBefore *IndexTelemetry `json:"telemetry_before,omitempty"`
+	TelemetryAfter  *IndexTelemetry `json:"telemetry_after,omitempty"`
+	TelemetryDelta  *IndexTelemetry `json:"telemetry_delta,omitempty"`
+}
+
+// loadDocs reads an NDJSON corpus, one JSON document per line. A document
+// carrying an "_id" field has it pulled out for the bulk action line and
+// removed from the indexed body.
+func loadDocs(filename string) ([]ndjsonDoc, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var docs []ndjsonDoc
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, lineNum, err)
+		}
+
+		var id string
+		if raw, ok := fields["_id"]; ok {
+			if s, ok := raw.(string); ok {
+				id = s
+			}
+			delete(fields, "_id")
+		}
+
+		body, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, lineNum, err)
+		}
+		docs = append(docs, ndjsonDoc{id: id, body: body})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// partitionDocs groups docs into batches of at most maxDocs documents and
+// maxBytes bytes (counting a small per-document allowance for the bulk
+// action/meta line), whichever limit is hit first.
+func partitionDocs(docs []ndjsonDoc, maxDocs, maxBytes int) []bulkBatch {
+	const actionLineOverhead = 64
+
+	var batches []bulkBatch
+	var current []ndjsonDoc
+	currentBytes := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			batches = append(batches, bulkBatch{docs: current})
+			current = nil
+			currentBytes = 0
+		}
+	}
+
+	for _, d := range docs {
+		docBytes := len(d.body) + actionLineOverhead
+		if len(current) > 0 && (len(current) >= maxDocs || currentBytes+docBytes > maxBytes) {
+			flush()
+		}
+		current = append(current, d)
+		currentBytes += docBytes
+	}
+	flush()
+
+	return batches
+}
+
+// buildBulkBody renders a batch as the newline-delimited action/source pairs
+// the _bulk API expects.
+func buildBulkBody(index string, docs []ndjsonDoc) []byte {
+	var buf bytes.Buffer
+	for _, d := range docs {
+		meta := map[string]interface{}{"_index": index}
+		if d.id != "" {
+			meta["_id"] = d.id
+		}
+		action, _ := json.Marshal(map[string]interface{}{"index": meta})
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(d.body)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// bulkActionResult is the per-item result ES reports for a "index" bulk
+// action.
+type bulkActionResult struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+type bulkItem struct {
+	Index *bulkActionResult `json:"index"`
+}
+
+type bulkResponseBody struct {
+	Took   int        `json:"took"`
+	Errors bool       `json:"errors"`
+	Items  []bulkItem `json:"items"`
+}
+
+// sendBulk issues a single _bulk request and parses its per-item results.
+func sendBulk(client *elasticsearch.Client, body []byte, timeout time.Duration) (*bulkResponseBody, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := client.Bulk(
+		bytes.NewReader(body),
+		client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bulk request failed with status %d: %s", resp.StatusCode, string(data))
+	}
+
+	var parsed bulkResponseBody
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing bulk response: %w", err)
+	}
+	return &parsed, nil
+}
+
+// runIndexBenchmark fans batches out across concurrency workers, each
+// issuing its own _bulk requests and recording latency into its own
+// histogram, merged at the end. (runPhase instead records search latencies
+// straight into one shared histogram.)
+func runIndexBenchmark(client *elasticsearch.Client, index string, batches []bulkBatch, concurrency, timeoutMs int) (*IndexResult, error) {
+	histogram := newLatencyHistogram()
+
+	var bulkCount, docsIndexed, docsFailed, totalBytes int64
+	itemErrors := make(map[string]int64)
+	var itemErrorsMu sync.Mutex
+
+	batchChan := make(chan bulkBatch, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		workerHistogram := newLatencyHistogram()
+
+		go func(workerHistogram *Histogram) {
+			defer wg.Done()
+			for batch := range batchChan {
+				body := buildBulkBody(index, batch.docs)
+				atomic.AddInt64(&totalBytes, int64(len(body)))
+
+				start := time.Now()
+				resp, err := sendBulk(client, body, time.Duration(timeoutMs)*time.Millisecond)
+				workerHistogram.Record(time.Since(start).Microseconds())
+				atomic.AddInt64(&bulkCount, 1)
+
+				if err != nil {
+					atomic.AddInt64(&docsFailed, int64(len(batch.docs)))
+					itemErrorsMu.Lock()
+					itemErrors["bulk_request_error"] += int64(len(batch.docs))
+					itemErrorsMu.Unlock()
+					continue
+				}
+
+				for _, item := range resp.Items {
+					if item.Index == nil {
+						continue
+					}
+					if item.Index.Status >= 200 && item.Index.Status < 300 {
+						atomic.AddInt64(&docsIndexed, 1)
+						continue
+					}
+					atomic.AddInt64(&docsFailed, 1)
+					errType := "unknown_error"
+					if item.Index.Error != nil && item.Index.Error.Type != "" {
+						errType = item.Index.Error.Type
+					}
+					itemErrorsMu.Lock()
+					itemErrors[errType]++
+					itemErrorsMu.Unlock()
+				}
+			}
+			histogram.Merge(workerHistogram)
+		}(workerHistogram)
+	}
+
+	startTime := time.Now()
+	for _, batch := range batches {
+		batchChan <- batch
+	}
+	close(batchChan)
+	wg.Wait()
+	elapsedSec := time.Since(startTime).Seconds()
+
+	result := &IndexResult{
+		DocsIndexed:          docsIndexed,
+		DocsFailed:           docsFailed,
+		BulkRequests:         bulkCount,
+		ElapsedSeconds:       elapsedSec,
+		DocsPerSec:           float64(docsIndexed) / elapsedSec,
+		BytesPerSec:          float64(totalBytes) / elapsedSec,
+		P50BulkLatencyMs:     float64(histogram.ValueAtPercentile(50)) / 1000,
+		P95BulkLatencyMs:     float64(histogram.ValueAtPercentile(95)) / 1000,
+		P99BulkLatencyMs:     float64(histogram.ValueAtPercentile(99)) / 1000,
+		BulkLatencyHistogram: microsPercentilesToMs(histogram, latencyHistogramPercentiles),
+	}
+	if len(itemErrors) > 0 {
+		result.ItemErrorTypes = itemErrors
+	}
+	return result, nil
+}
+
+// fetchIndexTelemetry collects the store size, segment count, and cumulative
+// merge/refresh time for index, combining _cat/indices (store size) with
+// _<index>/_stats (everything else). Both calls are scoped to index alone,
+// so results aren't polluted by other indices sharing the same nodes - the
+// usual case when comparing a flattened index against a typed one on the
+// same test cluster.
+func fetchIndexTelemetry(client *elasticsearch.Client, index string) (*IndexTelemetry, error) {
+	telemetry := &IndexTelemetry{}
+
+	catResp, err := client.Cat.Indices(
+		client.Cat.Indices.WithFormat("json"),
+		client.Cat.Indices.WithIndex(index),
+		client.Cat.Indices.WithBytes("b"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching _cat/indices: %w", err)
+	}
+	defer catResp.Body.Close()
+
+	var catRows []struct {
+		StoreSize string `json:"store.size"`
+	}
+	if err := json.NewDecoder(catResp.Body).Decode(&catRows); err != nil {
+		return nil, fmt.Errorf("parsing _cat/indices response: %w", err)
+	}
+	if len(catRows) > 0 {
+		if size, err := strconv.ParseInt(catRows[0].StoreSize, 10, 64); err == nil {
+			telemetry.StoreSizeBytes = size
+		}
+	}
+
+	statsResp, err := client.Indices.Stats(
+		client.Indices.Stats.WithIndex(index),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching _stats for index %s: %w", index, err)
+	}
+	defer statsResp.Body.Close()
+
+	var stats struct {
+		Indices map[string]struct {
+			Total struct {
+				Segments struct {
+					Count int64 `json:"count"`
+				} `json:"segments"`
+				Merges struct {
+					TotalTimeInMillis int64 `json:"total_time_in_millis"`
+				} `json:"merges"`
+				Refresh struct {
+					TotalTimeInMillis int64 `json:"total_time_in_millis"`
+				} `json:"refresh"`
+			} `json:"total"`
+		} `json:"indices"`
+	}
+	if err := json.NewDecoder(statsResp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("parsing _stats response: %w", err)
+	}
+	if indexStats, ok := stats.Indices[index]; ok {
+		telemetry.SegmentCount = indexStats.Total.Segments.Count
+		telemetry.MergesTotalTimeMs = indexStats.Total.Merges.TotalTimeInMillis
+		telemetry.RefreshTotalTimeMs = indexStats.Total.Refresh.TotalTimeInMillis
+	}
+
+	return telemetry, nil
+}
+
+func runIndexCommand(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	var (
+		esURL         = fs.String("es-url", "http://localhost:9200", "Elasticsearch URL")
+		indexName     = fs.String("index", "", "Target index name")
+		docsFile      = fs.String("docs-file", "", "NDJSON corpus file, one JSON document per line")
+		bulkSizeDocs  = fs.Int("bulk-size-docs", 500, "Maximum documents per _bulk request")
+		bulkSizeBytes = fs.Int("bulk-size-bytes", 5*1024*1024, "Maximum payload bytes per _bulk request")
+		concurrency   = fs.Int("concurrency", 8, "Number of concurrent bulk workers")
+		timeoutMs     = fs.Int("timeout-ms", 30000, "Bulk request timeout in milliseconds")
+		outputFile    = fs.String("output", "index_results.json", "Output JSON file")
+	)
+	fs.Parse(args)
+
+	if *indexName == "" {
+		fmt.Fprintf(os.Stderr, "ERROR: --index is required\n")
+		os.Exit(1)
+	}
+	if *docsFile == "" {
+		fmt.Fprintf(os.Stderr, "ERROR: --docs-file is required\n")
+		os.Exit(1)
+	}
+
+	docs, err := loadDocs(*docsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR loading docs: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Loaded %d documents\n", len(docs))
+
+	client, err := newESClient(*esURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Collecting pre-run index telemetry...\n")
+	before, beforeErr := fetchIndexTelemetry(client, *indexName)
+	if beforeErr != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: could not collect pre-run telemetry: %v\n", beforeErr)
+	}
+
+	batches := partitionDocs(docs, *bulkSizeDocs, *bulkSizeBytes)
+	fmt.Printf("Indexing %d documents across %d bulk requests (concurrency=%d)...\n", len(docs), len(batches), *concurrency)
+
+	result, err := runIndexBenchmark(client, *indexName, batches, *concurrency, *timeoutMs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR during index benchmark: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Collecting post-run index telemetry...\n")
+	after, afterErr := fetchIndexTelemetry(client, *indexName)
+	if afterErr != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: could not collect post-run telemetry: %v\n", afterErr)
+	}
+	if before != nil && after != nil {
+		result.TelemetryBefore = before
+		result.TelemetryAfter = after
+		result.TelemetryDelta = after.delta(before)
+	}
+
+	fmt.Printf("═══════════════════════════════════════\n")
+	fmt.Printf("INDEX RESULTS\n")
+	fmt.Printf("═══════════════════════════════════════\n")
+	fmt.Printf("Docs indexed:         %d\n", result.DocsIndexed)
+	fmt.Printf("Docs failed:          %d\n", result.DocsFailed)
+	fmt.Printf("Bulk requests:        %d\n", result.BulkRequests)
+	fmt.Printf("───────────────────────────────────────\n")
+	fmt.Printf("Elapsed:              %.2fs\n", result.ElapsedSeconds)
+	fmt.Printf("Throughput:           %.1f docs/sec, %.1f bytes/sec\n", result.DocsPerSec, result.BytesPerSec)
+	fmt.Printf("───────────────────────────────────────\n")
+	fmt.Printf("p50 bulk latency:     %.2f ms\n", result.P50BulkLatencyMs)
+	fmt.Printf("p95 bulk latency:     %.2f ms\n", result.P95BulkLatencyMs)
+	fmt.Printf("p99 bulk latency:     %.2f ms\n", result.P99BulkLatencyMs)
+	if result.TelemetryDelta != nil {
+		fmt.Printf("───────────────────────────────────────\n")
+		fmt.Printf("Store size delta:     %+d bytes\n", result.TelemetryDelta.StoreSizeBytes)
+		fmt.Printf("Segment count delta:  %+d\n", result.TelemetryDelta.SegmentCount)
+		fmt.Printf("Merge time delta:     %+d ms\n", result.TelemetryDelta.MergesTotalTimeMs)
+		fmt.Printf("Refresh time delta:   %+d ms\n", result.TelemetryDelta.RefreshTotalTimeMs)
+	}
+	fmt.Printf("═══════════════════════════════════════\n")
+
+	outputJSON, _ := json.MarshalIndent(result, "", "  ")
+	if err := os.WriteFile(*outputFile, outputJSON, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR writing output: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n✓ Results written to %s\n", *outputFile)
+}
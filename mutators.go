@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// QueryMutator derives a variant query from a base query, e.g. by attaching
+// an aggregation, a sort clause, or deep pagination. Each mutator is applied
+// independently across the whole query set so flattened vs typed mappings
+// can be compared across the full range of query shapes, not just the
+// filter-only queries in the input file.
+type QueryMutator interface {
+	Name() string
+	Mutate(q *Query, rng *rand.Rand) *Query
+}
+
+// MutatorConfig bundles the knobs the built-in mutators expose via flags.
+type MutatorConfig struct {
+	TermsAggSize      int
+	TopHitsSize       int
+	TimestampField    string
+	PaginationMaxFrom int
+	PaginationStep    int
+}
+
+var calendarIntervals = []string{"minute", "hour", "day", "week", "month"}
+
+// fieldPathFor returns field, prefixed with "data." when index is the
+// flattened benchmark index, matching how the corpus stores raw fields
+// under a single flattened "data" field instead of one mapped field per key.
+func fieldPathFor(index, field string) string {
+	if index == "bench_flattened" {
+		return "data." + field
+	}
+	return field
+}
+
+// copyQueryBody returns a copy of q with an independent, mutable Body map so
+// mutators can add aggregations/sort/etc without touching the original.
+func copyQueryBody(q *Query) *Query {
+	mutated := *q
+	mutated.Body = make(map[string]interface{}, len(q.Body))
+	for k, v := range q.Body {
+		mutated.Body[k] = v
+	}
+	return &mutated
+}
+
+// termsAggMutator reproduces the tool's original behavior: a terms
+// aggregation over a random field extracted from the query's filters.
+type termsAggMutator struct{ size int }
+
+func (m termsAggMutator) Name() string { return "terms_agg" }
+
+func (m termsAggMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	fields := extractFieldsFromQuery(q)
+	if len(fields) == 0 {
+		return mutated
+	}
+	field := fields[rng.Intn(len(fields))]
+
+	mutated.Body["aggs"] = map[string]interface{}{
+		"field_values": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field": fieldPathFor(q.Index, field),
+				"size":  m.size,
+			},
+		},
+	}
+	return mutated
+}
+
+// dateHistogramAggMutator buckets matches into a date_histogram over a
+// configurable timestamp field, with a randomly chosen calendar interval.
+type dateHistogramAggMutator struct{ timestampField string }
+
+func (m dateHistogramAggMutator) Name() string { return "date_histogram" }
+
+func (m dateHistogramAggMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	interval := calendarIntervals[rng.Intn(len(calendarIntervals))]
+
+	mutated.Body["aggs"] = map[string]interface{}{
+		"over_time": map[string]interface{}{
+			"date_histogram": map[string]interface{}{
+				"field":             fieldPathFor(q.Index, m.timestampField),
+				"calendar_interval": interval,
+			},
+		},
+	}
+	return mutated
+}
+
+// cardinalityAggMutator estimates the number of distinct values of a random
+// field extracted from the query's filters.
+type cardinalityAggMutator struct{}
+
+func (m cardinalityAggMutator) Name() string { return "cardinality" }
+
+func (m cardinalityAggMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	fields := extractFieldsFromQuery(q)
+	if len(fields) == 0 {
+		return mutated
+	}
+	field := fields[rng.Intn(len(fields))]
+
+	mutated.Body["aggs"] = map[string]interface{}{
+		"distinct_values": map[string]interface{}{
+			"cardinality": map[string]interface{}{
+				"field": fieldPathFor(q.Index, field),
+			},
+		},
+	}
+	return mutated
+}
+
+// topHitsMutator buckets by a random field and returns the top matching
+// documents within each bucket, exercising the more expensive sub-agg path.
+type topHitsMutator struct{ size int }
+
+func (m topHitsMutator) Name() string { return "top_hits" }
+
+func (m topHitsMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	fields := extractFieldsFromQuery(q)
+	if len(fields) == 0 {
+		return mutated
+	}
+	field := fields[rng.Intn(len(fields))]
+
+	mutated.Body["aggs"] = map[string]interface{}{
+		"field_values": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field": fieldPathFor(q.Index, field),
+				"size":  5,
+			},
+			"aggs": map[string]interface{}{
+				"top_matches": map[string]interface{}{
+					"top_hits": map[string]interface{}{
+						"size": m.size,
+					},
+				},
+			},
+		},
+	}
+	return mutated
+}
+
+// sortMutator replaces relevance scoring with a sort on a random field
+// extracted from the query's filters, in a random direction.
+type sortMutator struct{}
+
+func (m sortMutator) Name() string { return "sort" }
+
+func (m sortMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	fields := extractFieldsFromQuery(q)
+	if len(fields) == 0 {
+		return mutated
+	}
+	field := fields[rng.Intn(len(fields))]
+	direction := "asc"
+	if rng.Intn(2) == 1 {
+		direction = "desc"
+	}
+
+	mutated.Body["sort"] = []interface{}{
+		map[string]interface{}{
+			fieldPathFor(q.Index, field): map[string]interface{}{"order": direction},
+		},
+	}
+	return mutated
+}
+
+// paginationMutator sweeps "from" across a configurable range to exercise
+// deep pagination, which is where flattened and typed mappings tend to
+// diverge most on CPU and memory cost.
+type paginationMutator struct {
+	maxFrom int
+	step    int
+}
+
+func (m paginationMutator) Name() string { return "pagination" }
+
+func (m paginationMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	steps := m.maxFrom / m.step
+	if steps < 1 {
+		steps = 1
+	}
+	from := rng.Intn(steps+1) * m.step
+
+	mutated.Body["from"] = from
+	mutated.Body["size"] = 10
+	return mutated
+}
+
+// highlightMutator requests highlighted snippets for every field the query
+// filters on, forcing the highlighter to load and scan the matched fields.
+type highlightMutator struct{}
+
+func (m highlightMutator) Name() string { return "highlight" }
+
+func (m highlightMutator) Mutate(q *Query, rng *rand.Rand) *Query {
+	mutated := copyQueryBody(q)
+	fields := extractFieldsFromQuery(q)
+	if len(fields) == 0 {
+		return mutated
+	}
+
+	highlightFields := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		highlightFields[fieldPathFor(q.Index, field)] = map[string]interface{}{}
+	}
+	mutated.Body["highlight"] = map[string]interface{}{
+		"fields": highlightFields,
+	}
+	return mutated
+}
+
+// buildMutators resolves a comma-separated --mutators flag value into the
+// corresponding QueryMutator instances, in the order given.
+func buildMutators(names []string, cfg MutatorConfig) ([]QueryMutator, error) {
+	mutators := make([]QueryMutator, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		switch name {
+		case "terms_agg":
+			mutators = append(mutators, termsAggMutator{size: cfg.TermsAggSize})
+		case "date_histogram":
+			mutators = append(mutators, dateHistogramAggMutator{timestampField: cfg.TimestampField})
+		case "cardinality":
+			mutators = append(mutators, cardinalityAggMutator{})
+		case "top_hits":
+			mutators = append(mutators, topHitsMutator{size: cfg.TopHitsSize})
+		case "sort":
+			mutators = append(mutators, sortMutator{})
+		case "pagination":
+			mutators = append(mutators, paginationMutator{maxFrom: cfg.PaginationMaxFrom, step: cfg.PaginationStep})
+		case "highlight":
+			mutators = append(mutators, highlightMutator{})
+		default:
+			return nil, fmt.Errorf("unknown mutator %q (expected one of: terms_agg, date_histogram, cardinality, top_hits, sort, pagination, highlight)", name)
+		}
+	}
+	return mutators, nil
+}
+
+// applyMutator runs m over every query in queries, returning an independent
+// mutated copy of the set.
+func applyMutator(queries []Query, m QueryMutator, rng *rand.Rand) []Query {
+	mutated := make([]Query, len(queries))
+	for i, q := range queries {
+		mutated[i] = *m.Mutate(&q, rng)
+	}
+	return mutated
+}
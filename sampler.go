@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Sample is one point in a ClusterSampler's time series, correlating cluster
+// state with the latency results gathered concurrently by the benchmark
+// phase.
+type Sample struct {
+	TSeconds              float64 `json:"t_seconds"`
+	HeapPct               float64 `json:"heap_pct"`
+	GCYoungMsDelta        int64   `json:"gc_young_ms_delta"`
+	GCYoungCountDelta     int64   `json:"gc_young_count_delta"`
+	GCOldMsDelta          int64   `json:"gc_old_ms_delta"`
+	GCOldCountDelta       int64   `json:"gc_old_count_delta"`
+	SearchQueue           int64   `json:"search_queue"`
+	SearchRejectedDelta   int64   `json:"search_rejected_delta"`
+	IndexingQueue         int64   `json:"indexing_queue"`
+	IndexingRejectedDelta int64   `json:"indexing_rejected_delta"`
+	Load1m                float64 `json:"load_1m"`
+	Segments              int64   `json:"segments"`
+	QueryCacheHitRatio    float64 `json:"query_cache_hit_ratio"`
+	RequestCacheHitRatio  float64 `json:"request_cache_hit_ratio"`
+}
+
+// rawClusterStats is the subset of _nodes/stats/jvm,os,thread_pool (cluster-
+// wide, summed/aggregated across nodes) and index-scoped
+// _<index>/_stats/segments,query_cache,request_cache (the target index
+// alone) that this package cares about.
+type rawClusterStats struct {
+	HeapPct            float64
+	GCYoungTimeMs      int64
+	GCYoungCount       int64
+	GCOldTimeMs        int64
+	GCOldCount         int64
+	SearchQueue        int64
+	SearchRejected     int64
+	IndexingQueue      int64
+	IndexingRejected   int64
+	Load1m             float64
+	Segments           int64
+	QueryCacheHits     int64
+	QueryCacheMisses   int64
+	RequestCacheHits   int64
+	RequestCacheMisses int64
+}
+
+// ClusterSampler polls node and index stats at a fixed interval for the
+// duration of a benchmark phase, building a time series a user can line up
+// against the latency results to explain tail-latency spikes (GC pauses,
+// thread-pool rejections, etc). Sampling is best-effort: a failed poll just
+// leaves a gap in the series rather than failing the benchmark.
+type ClusterSampler struct {
+	client   *elasticsearch.Client
+	index    string
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples []Sample
+
+	havePrev             bool
+	prevGCYoungMs        int64
+	prevGCYoungCount     int64
+	prevGCOldMs          int64
+	prevGCOldCount       int64
+	prevSearchRejected   int64
+	prevIndexingRejected int64
+}
+
+func newClusterSampler(client *elasticsearch.Client, index string, interval time.Duration) *ClusterSampler {
+	return &ClusterSampler{client: client, index: index, interval: interval}
+}
+
+// run polls once immediately and then once per interval until ctx is
+// cancelled.
+func (s *ClusterSampler) run(ctx context.Context, startTime time.Time) {
+	s.sampleOnce(startTime)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sampleOnce(startTime)
+		}
+	}
+}
+
+func (s *ClusterSampler) sampleOnce(startTime time.Time) {
+	stats, err := fetchClusterStats(s.client, s.index)
+	if err != nil {
+		return
+	}
+
+	if status, err := fetchClusterHealthStatus(s.client); err == nil && status != "" && status != "green" {
+		fmt.Fprintf(os.Stderr, "WARNING: cluster health is %s during sampling\n", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var gcYoungMsDelta, gcYoungCountDelta, gcOldMsDelta, gcOldCountDelta int64
+	var searchRejectedDelta, indexingRejectedDelta int64
+	if s.havePrev {
+		gcYoungMsDelta = stats.GCYoungTimeMs - s.prevGCYoungMs
+		gcYoungCountDelta = stats.GCYoungCount - s.prevGCYoungCount
+		gcOldMsDelta = stats.GCOldTimeMs - s.prevGCOldMs
+		gcOldCountDelta = stats.GCOldCount - s.prevGCOldCount
+		searchRejectedDelta = stats.SearchRejected - s.prevSearchRejected
+		indexingRejectedDelta = stats.IndexingRejected - s.prevIndexingRejected
+	}
+	s.prevGCYoungMs = stats.GCYoungTimeMs
+	s.prevGCYoungCount = stats.GCYoungCount
+	s.prevGCOldMs = stats.GCOldTimeMs
+	s.prevGCOldCount = stats.GCOldCount
+	s.prevSearchRejected = stats.SearchRejected
+	s.prevIndexingRejected = stats.IndexingRejected
+	s.havePrev = true
+
+	s.samples = append(s.samples, Sample{
+		TSeconds:              time.Since(startTime).Seconds(),
+		HeapPct:               stats.HeapPct,
+		GCYoungMsDelta:        gcYoungMsDelta,
+		GCYoungCountDelta:     gcYoungCountDelta,
+		GCOldMsDelta:          gcOldMsDelta,
+		GCOldCountDelta:       gcOldCountDelta,
+		SearchQueue:           stats.SearchQueue,
+		SearchRejectedDelta:   searchRejectedDelta,
+		IndexingQueue:         stats.IndexingQueue,
+		IndexingRejectedDelta: indexingRejectedDelta,
+		Load1m:                stats.Load1m,
+		Segments:              stats.Segments,
+		QueryCacheHitRatio:    cacheHitRatio(stats.QueryCacheHits, stats.QueryCacheMisses),
+		RequestCacheHitRatio:  cacheHitRatio(stats.RequestCacheHits, stats.RequestCacheMisses),
+	})
+}
+
+func cacheHitRatio(hits, misses int64) float64 {
+	total := hits + misses
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// summary computes the correlation summary printed after the benchmark
+// phase: peak search queue depth, total GC pause time, and whether any
+// search-thread-pool rejections occurred - the signals needed to explain
+// tail-latency differences between index layouts.
+func (s *ClusterSampler) summary() (peakSearchQueue, totalGCPauseMs int64, rejectionsOccurred bool, samples []Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sample := range s.samples {
+		if sample.SearchQueue > peakSearchQueue {
+			peakSearchQueue = sample.SearchQueue
+		}
+		totalGCPauseMs += sample.GCYoungMsDelta + sample.GCOldMsDelta
+		if sample.SearchRejectedDelta > 0 {
+			rejectionsOccurred = true
+		}
+	}
+	return peakSearchQueue, totalGCPauseMs, rejectionsOccurred, s.samples
+}
+
+// fetchClusterStats combines _nodes/stats/jvm,os,thread_pool (cluster-wide
+// node health, aggregated across every node: heap % as the worst-case (max),
+// load average as the mean, everything else summed) with index-scoped
+// _<index>/_stats/segments,query_cache,request_cache, so segment count and
+// cache hit ratios reflect the index being benchmarked rather than every
+// index resident on the node.
+func fetchClusterStats(client *elasticsearch.Client, index string) (*rawClusterStats, error) {
+	nodeResp, err := client.Nodes.Stats(
+		client.Nodes.Stats.WithMetric("jvm", "os", "thread_pool"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching _nodes/stats: %w", err)
+	}
+	defer nodeResp.Body.Close()
+
+	var parsedNodes struct {
+		Nodes map[string]struct {
+			JVM struct {
+				Mem struct {
+					HeapUsedPercent float64 `json:"heap_used_percent"`
+				} `json:"mem"`
+				GC struct {
+					Collectors struct {
+						Young struct {
+							CollectionCount        int64 `json:"collection_count"`
+							CollectionTimeInMillis int64 `json:"collection_time_in_millis"`
+						} `json:"young"`
+						Old struct {
+							CollectionCount        int64 `json:"collection_count"`
+							CollectionTimeInMillis int64 `json:"collection_time_in_millis"`
+						} `json:"old"`
+					} `json:"collectors"`
+				} `json:"gc"`
+			} `json:"jvm"`
+			OS struct {
+				CPU struct {
+					LoadAverage struct {
+						OneM float64 `json:"1m"`
+					} `json:"load_average"`
+				} `json:"cpu"`
+			} `json:"os"`
+			ThreadPool struct {
+				Search struct {
+					Queue    int64 `json:"queue"`
+					Rejected int64 `json:"rejected"`
+				} `json:"search"`
+				Write struct {
+					Queue    int64 `json:"queue"`
+					Rejected int64 `json:"rejected"`
+				} `json:"write"`
+			} `json:"thread_pool"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(nodeResp.Body).Decode(&parsedNodes); err != nil {
+		return nil, fmt.Errorf("parsing _nodes/stats response: %w", err)
+	}
+
+	stats := &rawClusterStats{}
+	nodeCount := 0
+	for _, node := range parsedNodes.Nodes {
+		stats.HeapPct = math.Max(stats.HeapPct, node.JVM.Mem.HeapUsedPercent)
+		stats.GCYoungTimeMs += node.JVM.GC.Collectors.Young.CollectionTimeInMillis
+		stats.GCYoungCount += node.JVM.GC.Collectors.Young.CollectionCount
+		stats.GCOldTimeMs += node.JVM.GC.Collectors.Old.CollectionTimeInMillis
+		stats.GCOldCount += node.JVM.GC.Collectors.Old.CollectionCount
+		stats.Load1m += node.OS.CPU.LoadAverage.OneM
+		stats.SearchQueue += node.ThreadPool.Search.Queue
+		stats.SearchRejected += node.ThreadPool.Search.Rejected
+		stats.IndexingQueue += node.ThreadPool.Write.Queue
+		stats.IndexingRejected += node.ThreadPool.Write.Rejected
+		nodeCount++
+	}
+	if nodeCount > 0 {
+		stats.Load1m /= float64(nodeCount)
+	}
+
+	indexResp, err := client.Indices.Stats(
+		client.Indices.Stats.WithIndex(index),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching _stats for index %s: %w", index, err)
+	}
+	defer indexResp.Body.Close()
+
+	var parsedIndex struct {
+		Indices map[string]struct {
+			Total struct {
+				Segments struct {
+					Count int64 `json:"count"`
+				} `json:"segments"`
+				QueryCache struct {
+					HitCount  int64 `json:"hit_count"`
+					MissCount int64 `json:"miss_count"`
+				} `json:"query_cache"`
+				RequestCache struct {
+					HitCount  int64 `json:"hit_count"`
+					MissCount int64 `json:"miss_count"`
+				} `json:"request_cache"`
+			} `json:"total"`
+		} `json:"indices"`
+	}
+	if err := json.NewDecoder(indexResp.Body).Decode(&parsedIndex); err != nil {
+		return nil, fmt.Errorf("parsing _stats response: %w", err)
+	}
+	if indexStats, ok := parsedIndex.Indices[index]; ok {
+		stats.Segments = indexStats.Total.Segments.Count
+		stats.QueryCacheHits = indexStats.Total.QueryCache.HitCount
+		stats.QueryCacheMisses = indexStats.Total.QueryCache.MissCount
+		stats.RequestCacheHits = indexStats.Total.RequestCache.HitCount
+		stats.RequestCacheMisses = indexStats.Total.RequestCache.MissCount
+	}
+
+	return stats, nil
+}
+
+// fetchClusterHealthStatus issues _cluster/health and returns its status
+// (green/yellow/red).
+func fetchClusterHealthStatus(client *elasticsearch.Client) (string, error) {
+	resp, err := client.Cluster.Health()
+	if err != nil {
+		return "", fmt.Errorf("fetching _cluster/health: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("parsing _cluster/health response: %w", err)
+	}
+	return parsed.Status, nil
+}